@@ -0,0 +1,284 @@
+package apns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// sentBufferSize bounds how many recently written notifications the
+	// Client remembers, so it can replay whatever Apple silently dropped
+	// after an error-response frame. Notification identifiers are a
+	// monotonic counter (see NewPushNotification), so as long as fewer
+	// than sentBufferSize notifications are sent between the rejected
+	// one and the error response being reported, the lookup in
+	// handleErrorResponse is exact.
+	sentBufferSize = 1000
+
+	minReconnectBackoff = 100 * time.Millisecond
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// Client is a persistent, auto-reconnecting TLS connection to an APNs
+// binary gateway (ProductionGateway or SandboxGateway). It writes
+// notifications as they're handed to it and concurrently watches for the
+// 6-byte error-response frame Apple sends just before closing the socket,
+// resending anything Apple silently dropped as a result.
+type Client struct {
+	Gateway     string
+	Certificate tls.Certificate
+
+	// RootCAs overrides the system trust store used to verify the
+	// gateway's certificate. Leave nil to trust the system roots, which
+	// is what you want against the real Apple gateways.
+	RootCAs *x509.CertPool
+
+	// ErrorHandler, if set, is called with the notification Apple
+	// rejected and the error it returned. It is never called for
+	// notifications that are simply resent after an earlier failure.
+	ErrorHandler func(pn *PushNotification, err *ErrorResponse)
+
+	// UnmatchedErrorHandler, if set, is called when Apple reports an
+	// error for an identifier the Client no longer has in its sent
+	// buffer, i.e. sentBufferSize or more notifications were written
+	// since the rejected one. The Client errs on the side of resending
+	// everything it still remembers in this case rather than silently
+	// dropping it, but the caller likely wants to know this happened
+	// (e.g. to grow sentBufferSize).
+	UnmatchedErrorHandler func(err *ErrorResponse)
+
+	sendChan  chan *PushNotification
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+
+	mu   sync.Mutex
+	conn *tls.Conn
+	sent []*PushNotification // ring buffer of recently written notifications, oldest first
+}
+
+// NewClient constructs a Client from an already-loaded certificate.
+func NewClient(gateway string, cert tls.Certificate) *Client {
+	return &Client{
+		Gateway:     gateway,
+		Certificate: cert,
+		sendChan:    make(chan *PushNotification, 64),
+		done:        make(chan struct{}),
+	}
+}
+
+// NewClientWithFiles loads a PEM certificate and key from disk and
+// constructs a Client for the given gateway.
+func NewClientWithFiles(gateway, certFile, keyFile string) (*Client, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(gateway, cert), nil
+}
+
+// Connect dials the gateway, then starts the background frame reader and
+// the goroutine that drains SendChan. It must be called before Send or
+// SendChan will deliver anything.
+func (this *Client) Connect() error {
+	conn, err := this.dial()
+	if err != nil {
+		return err
+	}
+	this.mu.Lock()
+	this.conn = conn
+	this.mu.Unlock()
+
+	go this.readLoop()
+	go this.sendLoop()
+	return nil
+}
+
+// Close shuts down the connection and stops the background goroutines.
+// It is safe to call more than once; only the first call does any work,
+// and every call returns whatever error that first call produced.
+func (this *Client) Close() error {
+	this.closeOnce.Do(func() {
+		close(this.done)
+		this.mu.Lock()
+		defer this.mu.Unlock()
+		if this.conn != nil {
+			this.closeErr = this.conn.Close()
+		}
+	})
+	return this.closeErr
+}
+
+func (this *Client) dial() (*tls.Conn, error) {
+	config := &tls.Config{
+		Certificates: []tls.Certificate{this.Certificate},
+		RootCAs:      this.RootCAs,
+	}
+	return tls.Dial("tcp", this.Gateway, config)
+}
+
+// SendChan returns the channel callers can use to send notifications
+// asynchronously. Errors are reported to ErrorHandler rather than a
+// return value.
+func (this *Client) SendChan() chan<- *PushNotification {
+	return this.sendChan
+}
+
+func (this *Client) sendLoop() {
+	for {
+		select {
+		case pn := <-this.sendChan:
+			this.Send(pn)
+		case <-this.done:
+			return
+		}
+	}
+}
+
+// Send writes pn to the gateway and remembers it in case it needs to be
+// resent later. It blocks until the write completes.
+func (this *Client) Send(pn *PushNotification) error {
+	frame, err := pn.ToBytes()
+	if err != nil {
+		return err
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.remember(pn)
+	if this.conn == nil {
+		return errors.New("apns: not connected")
+	}
+	_, err = this.conn.Write(frame)
+	return err
+}
+
+// remember appends pn to the ring buffer of recently sent notifications,
+// trimming the oldest entries once it exceeds sentBufferSize. Callers
+// must hold this.mu.
+func (this *Client) remember(pn *PushNotification) {
+	this.sent = append(this.sent, pn)
+	if len(this.sent) > sentBufferSize {
+		this.sent = this.sent[len(this.sent)-sentBufferSize:]
+	}
+}
+
+// readLoop blocks reading 6-byte error-response frames off the current
+// connection. When Apple reports an error it reconnects and resends every
+// notification written after the rejected one, since Apple drops them
+// silently.
+func (this *Client) readLoop() {
+	for {
+		this.mu.Lock()
+		conn := this.conn
+		this.mu.Unlock()
+		if conn == nil {
+			return
+		}
+
+		resp, err := readErrorResponse(conn)
+		select {
+		case <-this.done:
+			return
+		default:
+		}
+
+		if err != nil {
+			// Connection dropped without an error frame, e.g. an idle
+			// timeout. Reconnect and keep watching.
+			this.reconnect()
+			continue
+		}
+
+		this.handleErrorResponse(resp)
+	}
+}
+
+func readErrorResponse(r io.Reader) (*ErrorResponse, error) {
+	var buf [6]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, err
+	}
+	return &ErrorResponse{
+		Command:    buf[0],
+		Status:     buf[1],
+		Identifier: int32(binary.BigEndian.Uint32(buf[2:])),
+	}, nil
+}
+
+func (this *Client) handleErrorResponse(resp *ErrorResponse) {
+	this.mu.Lock()
+	var failed *PushNotification
+	var resend []*PushNotification
+	matched := false
+	for i, pn := range this.sent {
+		if pn.Identifier == resp.Identifier {
+			failed = pn
+			resend = append(resend, this.sent[i+1:]...)
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		// The rejected identifier already aged out of the ring buffer,
+		// so we can't tell which notifications were written after it.
+		// Resend everything we still remember rather than silently
+		// dropping it.
+		resend = append(resend, this.sent...)
+	}
+	this.sent = nil
+	this.mu.Unlock()
+
+	if matched {
+		if this.ErrorHandler != nil {
+			this.ErrorHandler(failed, resp)
+		}
+	} else if this.UnmatchedErrorHandler != nil {
+		this.UnmatchedErrorHandler(resp)
+	}
+
+	this.reconnect()
+
+	for _, pn := range resend {
+		this.Send(pn)
+	}
+}
+
+// reconnect redials the gateway, backing off exponentially between
+// attempts since a dropped connection usually means Apple is under load.
+func (this *Client) reconnect() {
+	this.mu.Lock()
+	if this.conn != nil {
+		this.conn.Close()
+		this.conn = nil
+	}
+	this.mu.Unlock()
+
+	backoff := minReconnectBackoff
+	for {
+		select {
+		case <-this.done:
+			return
+		default:
+		}
+
+		conn, err := this.dial()
+		if err == nil {
+			this.mu.Lock()
+			this.conn = conn
+			this.mu.Unlock()
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
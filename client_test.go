@@ -0,0 +1,381 @@
+package apns
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockGateway is a minimal stand-in for Apple's binary APNs gateway: it
+// listens for TLS connections and remembers each one accept returns so
+// close can shut them all down. Tests read frames from and write
+// error-response frames to the returned connections themselves.
+type mockGateway struct {
+	listener net.Listener
+	certPool *x509.CertPool
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newMockGateway(t *testing.T) *mockGateway {
+	t.Helper()
+
+	cert, certPool := generateTestCert(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+	})
+	if err != nil {
+		t.Fatalf("listening for mock gateway: %v", err)
+	}
+
+	return &mockGateway{listener: listener, certPool: certPool}
+}
+
+func (this *mockGateway) addr() string {
+	return this.listener.Addr().String()
+}
+
+// accept blocks for a single incoming connection and remembers it.
+func (this *mockGateway) accept(t *testing.T) net.Conn {
+	t.Helper()
+	conn, err := this.listener.Accept()
+	if err != nil {
+		t.Fatalf("accepting mock gateway connection: %v", err)
+	}
+	this.mu.Lock()
+	this.conns = append(this.conns, conn)
+	this.mu.Unlock()
+	return conn
+}
+
+func (this *mockGateway) close() {
+	this.listener.Close()
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for _, conn := range this.conns {
+		conn.Close()
+	}
+}
+
+// generateTestCert creates a throwaway, self-signed certificate for
+// 127.0.0.1 and returns it alongside a pool that trusts it.
+func generateTestCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating test key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "apns test gateway"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating test certificate: %v", err)
+	}
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing test certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+
+	return cert, pool
+}
+
+func testPushNotification(identifier int32) *PushNotification {
+	pn := NewPushNotification()
+	pn.Identifier = identifier
+	pn.DeviceToken = "affed00d0000000000000000000000000000000000000000000000000000aaaa"
+	pn.Set("aps", NewPayload())
+	return pn
+}
+
+func TestClientSendWritesFrame(t *testing.T) {
+	gateway := newMockGateway(t)
+	defer gateway.close()
+
+	clientCert, _ := generateTestCert(t)
+	client := NewClient(gateway.addr(), clientCert)
+	client.RootCAs = gateway.certPool
+
+	serverDone := make(chan []byte, 1)
+	go func() {
+		conn := gateway.accept(t)
+		buf := make([]byte, 256)
+		n, _ := conn.Read(buf)
+		serverDone <- buf[:n]
+	}()
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Close()
+
+	pn := testPushNotification(42)
+	want, err := pn.ToBytes()
+	if err != nil {
+		t.Fatalf("ToBytes: %v", err)
+	}
+
+	if err := client.Send(pn); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	select {
+	case got := <-serverDone:
+		if string(got) != string(want) {
+			t.Fatalf("gateway received %x, want %x", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for gateway to receive the frame")
+	}
+}
+
+func TestClientCloseIsIdempotent(t *testing.T) {
+	gateway := newMockGateway(t)
+	defer gateway.close()
+
+	clientCert, _ := generateTestCert(t)
+	client := NewClient(gateway.addr(), clientCert)
+	client.RootCAs = gateway.certPool
+
+	go func() {
+		conn := gateway.accept(t)
+		io.Copy(io.Discard, conn)
+	}()
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("first Close: %v", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func TestClientResendsAfterErrorResponse(t *testing.T) {
+	gateway := newMockGateway(t)
+	defer gateway.close()
+
+	clientCert, _ := generateTestCert(t)
+	client := NewClient(gateway.addr(), clientCert)
+	client.RootCAs = gateway.certPool
+
+	var handled []*ErrorResponse
+	var handledMu sync.Mutex
+	client.ErrorHandler = func(pn *PushNotification, err *ErrorResponse) {
+		handledMu.Lock()
+		handled = append(handled, err)
+		handledMu.Unlock()
+	}
+
+	received := make(chan int32, 8)
+	serverReady := make(chan struct{})
+	go func() {
+		conn := gateway.accept(t)
+		close(serverReady)
+		readIdentifier(t, conn, received)
+
+		// Reject the second notification and hang up, as Apple does.
+		writeErrorResponse(t, conn, StatusInvalidToken, 2)
+		conn.Close()
+
+		conn = gateway.accept(t)
+		readIdentifier(t, conn, received) // the resend of #3
+	}()
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Close()
+	<-serverReady
+
+	if err := client.Send(testPushNotification(1)); err != nil {
+		t.Fatalf("Send #1: %v", err)
+	}
+	if err := client.Send(testPushNotification(2)); err != nil {
+		t.Fatalf("Send #2: %v", err)
+	}
+	if err := client.Send(testPushNotification(3)); err != nil {
+		t.Fatalf("Send #3: %v", err)
+	}
+
+	// #1 is read off the first connection; #2 is rejected before the
+	// gateway ever reads its bytes; #3 only shows up once the client
+	// reconnects and resends it.
+	var seen []int32
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-received:
+			seen = append(seen, id)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for notification %d", i+1)
+		}
+	}
+	if len(seen) != 2 || seen[0] != 1 || seen[1] != 3 {
+		t.Fatalf("gateway saw identifiers %v, want [1 3] (3 resent after 2 was rejected)", seen)
+	}
+
+	handledMu.Lock()
+	defer handledMu.Unlock()
+	if len(handled) != 1 || handled[0].Identifier != 2 || handled[0].Status != StatusInvalidToken {
+		t.Fatalf("ErrorHandler got %+v, want one InvalidToken error for identifier 2", handled)
+	}
+}
+
+// TestClientResendsEverythingWhenIdentifierUnmatched covers the case
+// where Apple reports an error for an identifier that has already been
+// evicted from the Client's ring buffer: rather than silently dropping
+// whatever was sent after it, the Client should resend everything it
+// still remembers and tell UnmatchedErrorHandler so the caller can
+// notice.
+func TestClientResendsEverythingWhenIdentifierUnmatched(t *testing.T) {
+	gateway := newMockGateway(t)
+	defer gateway.close()
+
+	clientCert, _ := generateTestCert(t)
+	client := NewClient(gateway.addr(), clientCert)
+	client.RootCAs = gateway.certPool
+
+	var unmatched []*ErrorResponse
+	var unmatchedMu sync.Mutex
+	client.UnmatchedErrorHandler = func(err *ErrorResponse) {
+		unmatchedMu.Lock()
+		unmatched = append(unmatched, err)
+		unmatchedMu.Unlock()
+	}
+
+	// Send more notifications than the ring buffer holds, so identifier
+	// 1 is long gone by the time the error for it comes back. Only the
+	// most recent sentBufferSize of them should still be remembered and
+	// resent.
+	const sendCount = sentBufferSize + 5
+	const wantResent = sentBufferSize
+
+	resendCount := make(chan int, 1)
+	serverReady := make(chan struct{})
+	go func() {
+		conn := gateway.accept(t)
+		close(serverReady)
+
+		// Drain every frame the client writes on the first connection
+		// without caring what's in them.
+		drained := make(chan int32, sendCount)
+		for i := 0; i < sendCount; i++ {
+			readIdentifier(t, conn, drained)
+			<-drained
+		}
+
+		// Report an identifier far older than the ring buffer can hold.
+		writeErrorResponse(t, conn, StatusInvalidToken, 1)
+		conn.Close()
+
+		conn = gateway.accept(t)
+		count := 0
+		received := make(chan int32, wantResent)
+		for count < wantResent {
+			readIdentifier(t, conn, received)
+			<-received
+			count++
+		}
+		resendCount <- count
+	}()
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Close()
+	<-serverReady
+
+	for i := 1; i <= sendCount; i++ {
+		if err := client.Send(testPushNotification(int32(i))); err != nil {
+			t.Fatalf("Send #%d: %v", i, err)
+		}
+	}
+
+	select {
+	case count := <-resendCount:
+		if count != wantResent {
+			t.Fatalf("gateway received %d resent notifications, want %d", count, wantResent)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the full resend")
+	}
+
+	unmatchedMu.Lock()
+	defer unmatchedMu.Unlock()
+	if len(unmatched) != 1 || unmatched[0].Identifier != 1 {
+		t.Fatalf("UnmatchedErrorHandler got %+v, want one call for identifier 1", unmatched)
+	}
+}
+
+// readIdentifier parses one frame off conn and pushes the notification
+// identifier it carries onto out.
+func readIdentifier(t *testing.T, conn net.Conn, out chan<- int32) {
+	t.Helper()
+
+	var header [5]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		t.Fatalf("reading frame header: %v", err)
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	body := make([]byte, length)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatalf("reading frame body: %v", err)
+	}
+
+	// Walk the TLV items to find NotificationIdentifierItemid.
+	for i := 0; i+3 <= len(body); {
+		itemID := body[i]
+		itemLen := binary.BigEndian.Uint16(body[i+1 : i+3])
+		itemStart := i + 3
+		if itemID == NotificationIdentifierItemid {
+			out <- int32(binary.BigEndian.Uint32(body[itemStart : itemStart+int(itemLen)]))
+			return
+		}
+		i = itemStart + int(itemLen)
+	}
+	t.Fatal("frame did not contain a notification identifier item")
+}
+
+func writeErrorResponse(t *testing.T, conn net.Conn, status uint8, identifier int32) {
+	t.Helper()
+
+	var buf [6]byte
+	buf[0] = 8
+	buf[1] = status
+	binary.BigEndian.PutUint32(buf[2:], uint32(identifier))
+	if _, err := conn.Write(buf[:]); err != nil {
+		t.Fatalf("writing error response: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package apns
+
+import (
+	"fmt"
+	"time"
+)
+
+// Gateway addresses for the binary APNs protocol. Production notifications
+// must be sent to ProductionGateway; SandboxGateway is for apps signed with
+// a development provisioning profile.
+const (
+	ProductionGateway = "gateway.push.apple.com:2195"
+	SandboxGateway    = "gateway.sandbox.push.apple.com:2195"
+)
+
+// Status codes Apple returns in the 6-byte error-response frame
+// (command byte 8). The mapping matches the one uniqush-push uses,
+// since Apple's own documentation of these values is sparse.
+const (
+	StatusNoErrors           uint8 = 0
+	StatusProcessingError    uint8 = 1
+	StatusMissingDeviceToken uint8 = 2
+	StatusMissingTopic       uint8 = 3
+	StatusMissingPayload     uint8 = 4
+	StatusInvalidTokenSize   uint8 = 5
+	StatusInvalidTopicSize   uint8 = 6
+	StatusInvalidPayloadSize uint8 = 7
+	StatusInvalidToken       uint8 = 8
+	StatusShutdown           uint8 = 10
+	StatusUnknown            uint8 = 255
+)
+
+var statusMessages = map[uint8]string{
+	StatusNoErrors:           "no errors encountered",
+	StatusProcessingError:    "processing error",
+	StatusMissingDeviceToken: "missing device token",
+	StatusMissingTopic:       "missing topic",
+	StatusMissingPayload:     "missing payload",
+	StatusInvalidTokenSize:   "invalid token size",
+	StatusInvalidTopicSize:   "invalid topic size",
+	StatusInvalidPayloadSize: "invalid payload size",
+	StatusInvalidToken:       "invalid token",
+	StatusShutdown:           "shutdown",
+	StatusUnknown:            "unknown error",
+}
+
+// ErrorResponse is the 6-byte frame (command=8) Apple writes to the socket
+// when it rejects a notification. Apple closes the connection immediately
+// afterward, which means every notification sent after the offending
+// Identifier was silently dropped and must be resent.
+type ErrorResponse struct {
+	Command    uint8
+	Status     uint8
+	Identifier int32
+}
+
+// Error satisfies the error interface by translating Status into the
+// message uniqush-push associates with it.
+func (this *ErrorResponse) Error() string {
+	msg, ok := statusMessages[this.Status]
+	if !ok {
+		msg = statusMessages[StatusUnknown]
+	}
+	return fmt.Sprintf("apns: identifier %d: %s (status %d)", this.Identifier, msg, this.Status)
+}
+
+// Unsubscribe reports whether this error means the device token should be
+// removed from the caller's database, since it will never succeed again.
+func (this *ErrorResponse) Unsubscribe() bool {
+	return this.Status == StatusInvalidToken || this.Status == StatusMissingDeviceToken
+}
+
+// HTTP2Error is returned by HTTP2Client.Send when Apple's HTTP/2 provider
+// API rejects a notification. It's the JSON equivalent of ErrorResponse
+// for the newer transport: Apple reports rejections as a non-200 status
+// with a `{"reason": "...", "timestamp": ...}` body instead of closing a
+// persistent connection.
+type HTTP2Error struct {
+	StatusCode int
+	Reason     string
+	Timestamp  time.Time
+}
+
+// Error satisfies the error interface.
+func (this *HTTP2Error) Error() string {
+	return fmt.Sprintf("apns: http2 request rejected with status %d: %s", this.StatusCode, this.Reason)
+}
+
+// Unsubscribe reports whether this error means the device token should be
+// removed from the caller's database. Apple documents "Unregistered" as
+// the HTTP/2 equivalent of the binary protocol's invalid-token status.
+func (this *HTTP2Error) Unsubscribe() bool {
+	return this.Reason == "Unregistered" || this.Reason == "BadDeviceToken"
+}
@@ -0,0 +1,110 @@
+package apns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"time"
+)
+
+// Feedback gateway addresses. Apple expects clients to poll these
+// periodically (daily is typical) rather than hold a connection open.
+const (
+	ProductionFeedbackGateway = "feedback.push.apple.com:2196"
+	SandboxFeedbackGateway    = "feedback.sandbox.push.apple.com:2196"
+)
+
+// FeedbackResponse is one tuple Apple emits for a device token that
+// rejected a notification: when it rejected it, and which token it was.
+type FeedbackResponse struct {
+	Timestamp   time.Time
+	DeviceToken string
+}
+
+// FeedbackClient connects to Apple's feedback service and streams the
+// device tokens it reports as no longer valid, so callers can prune them
+// from their own database. Apple closes the connection once it has sent
+// everything it has, so a single Receive call drains exactly one poll.
+type FeedbackClient struct {
+	Gateway     string
+	Certificate tls.Certificate
+	RootCAs     *x509.CertPool
+}
+
+// NewFeedbackClient constructs a FeedbackClient from an already-loaded
+// certificate.
+func NewFeedbackClient(gateway string, cert tls.Certificate) *FeedbackClient {
+	return &FeedbackClient{Gateway: gateway, Certificate: cert}
+}
+
+// NewFeedbackClientWithFiles loads a PEM certificate and key from disk
+// and constructs a FeedbackClient for the given gateway.
+func NewFeedbackClientWithFiles(gateway, certFile, keyFile string) (*FeedbackClient, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewFeedbackClient(gateway, cert), nil
+}
+
+// Receive connects to the feedback gateway, streams every tuple it sends
+// on the returned channel, and closes the channel once Apple closes the
+// connection. Errors encountered while reading are sent on errCh; the
+// caller should drain both channels until respCh closes.
+func (this *FeedbackClient) Receive() (respCh <-chan FeedbackResponse, errCh <-chan error) {
+	responses := make(chan FeedbackResponse)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(responses)
+
+		config := &tls.Config{
+			Certificates: []tls.Certificate{this.Certificate},
+			RootCAs:      this.RootCAs,
+		}
+		conn, err := tls.Dial("tcp", this.Gateway, config)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+
+		for {
+			resp, err := readFeedbackTuple(conn)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+			responses <- resp
+		}
+	}()
+
+	return responses, errs
+}
+
+// readFeedbackTuple reads one (timestamp, token length, token) tuple as
+// documented by Apple's legacy feedback service.
+func readFeedbackTuple(r io.Reader) (FeedbackResponse, error) {
+	var header [6]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return FeedbackResponse{}, err
+	}
+
+	timestamp := binary.BigEndian.Uint32(header[0:4])
+	tokenLen := binary.BigEndian.Uint16(header[4:6])
+
+	token := make([]byte, tokenLen)
+	if _, err := io.ReadFull(r, token); err != nil {
+		return FeedbackResponse{}, err
+	}
+
+	return FeedbackResponse{
+		Timestamp:   time.Unix(int64(timestamp), 0),
+		DeviceToken: hex.EncodeToString(token),
+	}, nil
+}
@@ -0,0 +1,86 @@
+package apns
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func writeFeedbackTuple(t *testing.T, w interface{ Write([]byte) (int, error) }, when time.Time, token string) {
+	t.Helper()
+
+	tokenBytes, err := hex.DecodeString(token)
+	if err != nil {
+		t.Fatalf("decoding test token: %v", err)
+	}
+
+	buf := make([]byte, 6+len(tokenBytes))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(when.Unix()))
+	binary.BigEndian.PutUint16(buf[4:6], uint16(len(tokenBytes)))
+	copy(buf[6:], tokenBytes)
+
+	if _, err := w.Write(buf); err != nil {
+		t.Fatalf("writing feedback tuple: %v", err)
+	}
+}
+
+func TestFeedbackClientReceivesTuples(t *testing.T) {
+	gateway := newMockGateway(t)
+	defer gateway.close()
+
+	clientCert, _ := generateTestCert(t)
+	client := NewFeedbackClient(gateway.addr(), clientCert)
+	client.RootCAs = gateway.certPool
+
+	want := []FeedbackResponse{
+		{Timestamp: time.Unix(1000, 0), DeviceToken: "affe0001"},
+		{Timestamp: time.Unix(2000, 0), DeviceToken: "affe0002"},
+	}
+
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		conn := gateway.accept(t)
+		for _, fr := range want {
+			writeFeedbackTuple(t, conn, fr.Timestamp, fr.DeviceToken)
+		}
+		conn.Close()
+	}()
+
+	respCh, errCh := client.Receive()
+
+	var got []FeedbackResponse
+	for respCh != nil || errCh != nil {
+		select {
+		case fr, ok := <-respCh:
+			if !ok {
+				respCh = nil
+				continue
+			}
+			got = append(got, fr)
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			t.Fatalf("unexpected error from Receive: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for feedback tuples")
+		}
+		if len(got) == len(want) {
+			break
+		}
+	}
+
+	<-serverDone
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d responses, want %d", len(got), len(want))
+	}
+	for i, fr := range got {
+		if fr.DeviceToken != want[i].DeviceToken || !fr.Timestamp.Equal(want[i].Timestamp) {
+			t.Errorf("response %d = %+v, want %+v", i, fr, want[i])
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package apns
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// HTTP/2 provider API gateway addresses. Apple deprecated the binary
+// protocol Client speaks in favor of this one; prefer it for new code.
+const (
+	HTTP2ProductionGateway = "https://api.push.apple.com"
+	HTTP2SandboxGateway    = "https://api.sandbox.push.apple.com"
+)
+
+// MaxHTTP2PayloadSizeBytes is the aps payload limit Apple enforces over
+// the HTTP/2 provider API, considerably larger than MAX_PAYLOAD_SIZE_BYTES,
+// which only applies to the binary protocol's fixed-size frame.
+const MaxHTTP2PayloadSizeBytes = 4096
+
+// HTTP2Client sends notifications over Apple's HTTP/2 provider API
+// instead of the binary gateway Client speaks. Authenticate it with
+// either a certificate (NewHTTP2Client) or a provider JWT
+// (NewHTTP2ClientWithToken).
+type HTTP2Client struct {
+	Gateway    string
+	Authorizer *ProviderToken
+
+	client *http.Client
+}
+
+// NewHTTP2Client constructs an HTTP2Client that authenticates with a
+// client certificate, as Client does for the binary protocol.
+func NewHTTP2Client(gateway string, cert tls.Certificate) *HTTP2Client {
+	return &HTTP2Client{
+		Gateway: gateway,
+		client:  newHTTP2HTTPClient(&tls.Config{Certificates: []tls.Certificate{cert}}),
+	}
+}
+
+// NewHTTP2ClientWithToken constructs an HTTP2Client that authenticates
+// each request with a bearer token from token, refreshing it as needed.
+func NewHTTP2ClientWithToken(gateway string, token *ProviderToken) *HTTP2Client {
+	return &HTTP2Client{
+		Gateway:    gateway,
+		Authorizer: token,
+		client:     newHTTP2HTTPClient(&tls.Config{}),
+	}
+}
+
+func newHTTP2HTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http2.Transport{TLSClientConfig: tlsConfig},
+	}
+}
+
+// Send POSTs pn to Apple's HTTP/2 provider API. It returns an *HTTP2Error
+// if Apple rejects the notification, or the underlying transport error
+// if the request never completed.
+func (this *HTTP2Client) Send(pn *PushNotification) error {
+	payload, err := pn.PayloadJSON()
+	if err != nil {
+		return err
+	}
+	if len(payload) > MaxHTTP2PayloadSizeBytes {
+		return fmt.Errorf("apns: payload is larger than the %d byte limit", MaxHTTP2PayloadSizeBytes)
+	}
+
+	url := fmt.Sprintf("%s/3/device/%s", this.Gateway, pn.DeviceToken)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if err := this.setHeaders(req, pn); err != nil {
+		return err
+	}
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var body struct {
+		Reason    string `json:"reason"`
+		Timestamp int64  `json:"timestamp"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return &HTTP2Error{StatusCode: resp.StatusCode}
+	}
+	return &HTTP2Error{
+		StatusCode: resp.StatusCode,
+		Reason:     body.Reason,
+		Timestamp:  time.UnixMilli(body.Timestamp),
+	}
+}
+
+func (this *HTTP2Client) setHeaders(req *http.Request, pn *PushNotification) error {
+	if pn.ApnsID != "" {
+		req.Header.Set("apns-id", pn.ApnsID)
+	}
+	if pn.Expiry != 0 {
+		req.Header.Set("apns-expiration", strconv.FormatUint(uint64(pn.Expiry), 10))
+	}
+	req.Header.Set("apns-priority", strconv.Itoa(int(pn.Priority)))
+	if pn.Topic != "" {
+		req.Header.Set("apns-topic", pn.Topic)
+	}
+	if pn.PushType != "" {
+		req.Header.Set("apns-push-type", pn.PushType)
+	}
+	if pn.CollapseID != "" {
+		req.Header.Set("apns-collapse-id", pn.CollapseID)
+	}
+
+	if this.Authorizer != nil {
+		token, err := this.Authorizer.Token()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("authorization", "bearer "+token)
+	}
+	return nil
+}
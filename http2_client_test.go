@@ -0,0 +1,120 @@
+package apns
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func newTestHTTP2Server(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	return server
+}
+
+func trustServer(t *testing.T, client *HTTP2Client, server *httptest.Server) {
+	t.Helper()
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	client.client.Transport.(*http2.Transport).TLSClientConfig.RootCAs = pool
+}
+
+func TestHTTP2ClientSendSuccess(t *testing.T) {
+	var gotPath string
+	var gotHeaders http.Header
+	server := newTestHTTP2Server(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotHeaders = r.Header
+		w.WriteHeader(http.StatusOK)
+	})
+	defer server.Close()
+
+	clientCert, _ := generateTestCert(t)
+	client := NewHTTP2Client(server.URL, clientCert)
+	trustServer(t, client, server)
+
+	pn := testPushNotification(7)
+	pn.Topic = "com.example.app"
+	pn.PushType = "alert"
+	pn.ApnsID = "9f4e5b8a-1c3d-4a2b-9e6f-0d1c2b3a4f5e"
+
+	if err := client.Send(pn); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	wantPath := "/3/device/" + pn.DeviceToken
+	if gotPath != wantPath {
+		t.Errorf("request path = %q, want %q", gotPath, wantPath)
+	}
+	if got := gotHeaders.Get("apns-id"); got != pn.ApnsID {
+		t.Errorf("apns-id header = %q, want %q", got, pn.ApnsID)
+	}
+	if got := gotHeaders.Get("apns-topic"); got != "com.example.app" {
+		t.Errorf("apns-topic header = %q, want %q", got, "com.example.app")
+	}
+	if got := gotHeaders.Get("apns-push-type"); got != "alert" {
+		t.Errorf("apns-push-type header = %q, want %q", got, "alert")
+	}
+}
+
+func TestHTTP2ClientOmitsApnsIDByDefault(t *testing.T) {
+	var gotHeaders http.Header
+	server := newTestHTTP2Server(t, func(w http.ResponseWriter, r *http.Request) {
+		gotHeaders = r.Header
+		w.WriteHeader(http.StatusOK)
+	})
+	defer server.Close()
+
+	clientCert, _ := generateTestCert(t)
+	client := NewHTTP2Client(server.URL, clientCert)
+	trustServer(t, client, server)
+
+	// pn.Identifier is always set (it's a monotonic counter), but that's
+	// not a UUID and must never leak into apns-id.
+	if err := client.Send(testPushNotification(7)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := gotHeaders.Get("apns-id"); got != "" {
+		t.Errorf("apns-id header = %q, want empty so Apple generates one", got)
+	}
+}
+
+func TestHTTP2ClientSendError(t *testing.T) {
+	server := newTestHTTP2Server(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"reason":    "BadDeviceToken",
+			"timestamp": 1234567890000,
+		})
+	})
+	defer server.Close()
+
+	clientCert, _ := generateTestCert(t)
+	client := NewHTTP2Client(server.URL, clientCert)
+	trustServer(t, client, server)
+
+	err := client.Send(testPushNotification(1))
+	if err == nil {
+		t.Fatal("Send: want error, got nil")
+	}
+
+	http2Err, ok := err.(*HTTP2Error)
+	if !ok {
+		t.Fatalf("Send error type = %T, want *HTTP2Error", err)
+	}
+	if http2Err.StatusCode != http.StatusGone {
+		t.Errorf("StatusCode = %d, want %d", http2Err.StatusCode, http.StatusGone)
+	}
+	if http2Err.Reason != "BadDeviceToken" {
+		t.Errorf("Reason = %q, want %q", http2Err.Reason, "BadDeviceToken")
+	}
+	if !http2Err.Unsubscribe() {
+		t.Error("Unsubscribe() = false, want true for BadDeviceToken")
+	}
+}
@@ -0,0 +1,99 @@
+package apns
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// tokenLifetime bounds how long a generated provider token is reused
+// before ProviderToken mints a new one. Apple allows tokens to live up
+// to an hour; refreshing at 50 minutes leaves margin for clock skew.
+const tokenLifetime = 50 * time.Minute
+
+// ProviderToken generates and caches the JWT Apple's HTTP/2 provider API
+// accepts as an alternative to certificate authentication. Pass one to
+// NewHTTP2ClientWithToken instead of a tls.Certificate.
+type ProviderToken struct {
+	TeamID     string
+	KeyID      string
+	SigningKey *ecdsa.PrivateKey
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+// NewProviderToken constructs a ProviderToken from the team and key
+// identifiers shown in App Store Connect and the ES256 private key
+// downloaded alongside them.
+func NewProviderToken(teamID, keyID string, signingKey *ecdsa.PrivateKey) *ProviderToken {
+	return &ProviderToken{TeamID: teamID, KeyID: keyID, SigningKey: signingKey}
+}
+
+// Token returns a valid bearer token, generating a new one if the cached
+// token has passed tokenLifetime.
+func (this *ProviderToken) Token() (string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.token != "" && time.Since(this.issuedAt) < tokenLifetime {
+		return this.token, nil
+	}
+
+	now := time.Now()
+	signingInput, err := jwtSigningInput(this.KeyID, this.TeamID, now)
+	if err != nil {
+		return "", err
+	}
+	signature, err := signES256(this.SigningKey, signingInput)
+	if err != nil {
+		return "", err
+	}
+
+	this.token = signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+	this.issuedAt = now
+	return this.token, nil
+}
+
+// jwtSigningInput builds the base64url(header) + "." + base64url(claims)
+// portion of the JWT, i.e. everything that gets signed.
+func jwtSigningInput(keyID, teamID string, issuedAt time.Time) (string, error) {
+	header, err := json.Marshal(struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{Alg: "ES256", Kid: keyID})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+	}{Iss: teamID, Iat: issuedAt.Unix()})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims), nil
+}
+
+// signES256 produces the raw r||s signature JWS expects for ES256, as
+// opposed to the ASN.1 DER encoding ecdsa.SignASN1 returns.
+func signES256(key *ecdsa.PrivateKey, signingInput string) ([]byte, error) {
+	hash := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	size := (key.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return signature, nil
+}
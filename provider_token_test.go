@@ -0,0 +1,68 @@
+package apns
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestProviderTokenFormatAndCaching(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating test key: %v", err)
+	}
+
+	pt := NewProviderToken("TEAM123", "KEY456", key)
+
+	token, err := pt.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token has %d parts, want 3 (header.claims.signature)", len(parts))
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		t.Fatalf("decoding header: %v", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		t.Fatalf("unmarshaling header: %v", err)
+	}
+	if header.Alg != "ES256" || header.Kid != "KEY456" {
+		t.Errorf("header = %+v, want alg=ES256 kid=KEY456", header)
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("decoding claims: %v", err)
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+	}
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		t.Fatalf("unmarshaling claims: %v", err)
+	}
+	if claims.Iss != "TEAM123" {
+		t.Errorf("claims.Iss = %q, want %q", claims.Iss, "TEAM123")
+	}
+
+	again, err := pt.Token()
+	if err != nil {
+		t.Fatalf("Token (second call): %v", err)
+	}
+	if again != token {
+		t.Error("Token() minted a new token before tokenLifetime elapsed")
+	}
+}
@@ -1,14 +1,12 @@
 package apns
 
 import (
-	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"math/rand"
 	"strconv"
-	"time"
+	"sync/atomic"
 )
 
 // Push commands always start with command value 2.
@@ -17,10 +15,13 @@ const PUSH_COMMAND_VALUE = 2
 // Your total notification payload cannot exceed 256 bytes.
 const MAX_PAYLOAD_SIZE_BYTES = 256
 
-// Every push notification gets a pseudo-unique identifier;
-// this establishes the upper boundary for it. Apple will return
-// this identifier if there is an issue sending your notification.
-const IDENTIFIER_UBOUND = 9999
+// identifierCounter hands out the identifiers NewPushNotification
+// assigns. It's a monotonic counter rather than a random value drawn
+// from a small range so that Client can reliably tell two in-flight
+// notifications apart: Apple returns this identifier verbatim when it
+// rejects a notification, and Client correlates it against a buffer of
+// recently sent notifications to figure out what to resend.
+var identifierCounter int32
 
 const (
 	DeviceTokenItemid = 1
@@ -37,12 +38,34 @@ const (
 	PriorityLength = 1
 )
 
+// InterruptionLevel values control how iOS presents a notification when
+// the device is in Focus or Do Not Disturb. See Apple's "Customizing the
+// Delivery of Time-Sensitive Notifications" documentation.
+const (
+	InterruptionLevelPassive       = "passive"
+	InterruptionLevelActive        = "active"
+	InterruptionLevelTimeSensitive = "time-sensitive"
+	InterruptionLevelCritical      = "critical"
+)
+
 // Alert is an interface here because it supports either a string
 // or a dictionary, represented within by an AlertDictionary struct.
+//
+// Badge is a pointer so a zero badge (clear the badge) can be
+// distinguished from an unset one (leave the badge alone): an exported
+// int field set to 0 is indistinguishable from its zero value once
+// omitempty is involved, but a nil *int isn't.
 type Payload struct {
-	Alert interface{} `json:"alert,omitempty"`
-	Badge int         `json:"badge,omitempty"`
-	Sound string      `json:"sound,omitempty"`
+	Alert             interface{} `json:"alert,omitempty"`
+	Badge             *int        `json:"badge,omitempty"`
+	Sound             string      `json:"sound,omitempty"`
+	Category          string      `json:"category,omitempty"`
+	ThreadID          string      `json:"thread-id,omitempty"`
+	ContentAvailable  int         `json:"content-available,omitempty"`
+	MutableContent    int         `json:"mutable-content,omitempty"`
+	TargetContentID   string      `json:"target-content-id,omitempty"`
+	InterruptionLevel string      `json:"interruption-level,omitempty"`
+	RelevanceScore    *float64    `json:"relevance-score,omitempty"`
 }
 
 // Constructor.
@@ -50,10 +73,25 @@ func NewPayload() *Payload {
 	return new(Payload)
 }
 
+// SetBadge sets the badge to n, including a badge of 0 (which clears it)
+// rather than omitting it the way a bare int field would.
+func (this *Payload) SetBadge(n int) {
+	this.Badge = &n
+}
+
+// SetRelevanceScore sets the relevance score, including a score of 0.
+func (this *Payload) SetRelevanceScore(score float64) {
+	this.RelevanceScore = &score
+}
+
 // From the APN docs: "Use the ... alert dictionary in general only if you absolutely need to."
 // The AlertDictionary is suitable for specific localization needs.
 type AlertDictionary struct {
+	Title        string   `json:"title,omitempty"`
+	Subtitle     string   `json:"subtitle,omitempty"`
 	Body         string   `json:"body,omitempty"`
+	TitleLocKey  string   `json:"title-loc-key,omitempty"`
+	TitleLocArgs []string `json:"title-loc-args,omitempty"`
 	ActionLocKey string   `json:"action-loc-key,omitempty"`
 	LocKey       string   `json:"loc-key,omitempty"`
 	LocArgs      []string `json:"loc-args,omitempty"`
@@ -73,34 +111,32 @@ type PushNotification struct {
 	DeviceToken string
 	payload     map[string]interface{}
 	Priority    uint8
+
+	// Topic, PushType, CollapseID, and ApnsID are only meaningful over
+	// the HTTP/2 provider API (see HTTP2Client); the binary gateway has
+	// no equivalent of them and ToBytes ignores them entirely.
+	Topic      string
+	PushType   string
+	CollapseID string
+
+	// ApnsID, if set, must be a canonical UUID string; HTTP2Client sends
+	// it as the apns-id header and Apple echoes it back in its response.
+	// Leave it unset to let Apple generate one itself. Identifier is
+	// unsuitable for this: it's a bare integer counter, not the UUID
+	// Apple's HTTP/2 API requires.
+	ApnsID string
 }
 
-// Constructor. Also initializes the pseudo-random identifier.
+// Constructor. Also assigns the next identifier.
 func NewPushNotification() (pn *PushNotification) {
 	pn = new(PushNotification)
 	pn.payload = make(map[string]interface{})
-	pn.Identifier = rand.New(rand.NewSource(time.Now().UnixNano())).Int31n(IDENTIFIER_UBOUND)
+	pn.Identifier = atomic.AddInt32(&identifierCounter, 1)
 	pn.Priority = 10
 	return
 }
 
 func (this *PushNotification) AddPayload(p *Payload) {
-	// This deserves some explanation.
-	//
-	// Setting an exported field of type int to 0
-	// triggers the omitempty behavior if you've set it.
-	// Since the badge is optional, we should omit it if
-	// it's not set. However, we want to include it if the
-	// value is 0, so there's a hack in push_notification.go
-	// that exploits the fact that Apple treats -1 for a
-	// badge value as though it were 0 (i.e. it clears the
-	// badge but doesn't stop the notification from going
-	// through successfully.)
-	//
-	// Still a hack though :)
-	if p.Badge == 0 {
-		p.Badge = -1
-	}
 	this.Set("aps", p)
 }
 
@@ -124,6 +160,14 @@ func (this *PushNotification) PayloadString() (string, error) {
 // Returns a byte array of the complete PushNotification struct. This array
 // is what should be transmitted to the APN Service.
 func (this *PushNotification) ToBytes() ([]byte, error) {
+	return this.AppendBytes(nil)
+}
+
+// AppendBytes serializes the notification and appends it to dst, growing
+// dst if it doesn't have enough spare capacity, and returns the result.
+// Reusing dst across notifications (e.g. a sender loop's scratch buffer)
+// avoids allocating a fresh frame for every send.
+func (this *PushNotification) AppendBytes(dst []byte) ([]byte, error) {
 	token, err := hex.DecodeString(this.DeviceToken)
 	if err != nil {
 		return nil, err
@@ -136,26 +180,48 @@ func (this *PushNotification) ToBytes() ([]byte, error) {
 		return nil, errors.New("payload is larger than the " + strconv.Itoa(MAX_PAYLOAD_SIZE_BYTES) + " byte limit")
 	}
 
-	frameBuffer := new(bytes.Buffer)
-	binary.Write(frameBuffer, binary.BigEndian, uint8(DeviceTokenItemid))
-	binary.Write(frameBuffer, binary.BigEndian, uint16(DeviceTokenLength))
-	binary.Write(frameBuffer, binary.BigEndian, token)
-	binary.Write(frameBuffer, binary.BigEndian, uint8(PayloadItemid))
-	binary.Write(frameBuffer, binary.BigEndian, uint16(len(payload)))
-	binary.Write(frameBuffer, binary.BigEndian, payload)
-	binary.Write(frameBuffer, binary.BigEndian, uint8(NotificationIdentifierItemid))
-	binary.Write(frameBuffer, binary.BigEndian, uint16(NotificationIdentifierLength))
-	binary.Write(frameBuffer, binary.BigEndian, this.Identifier)
-	binary.Write(frameBuffer, binary.BigEndian, uint8(ExpirationDateItemid))
-	binary.Write(frameBuffer, binary.BigEndian, uint16(ExpirationDateLength))
-	binary.Write(frameBuffer, binary.BigEndian, this.Expiry)
-	binary.Write(frameBuffer, binary.BigEndian, uint8(PriorityItemid))
-	binary.Write(frameBuffer, binary.BigEndian, uint16(PriorityLength))
-	binary.Write(frameBuffer, binary.BigEndian, this.Priority)
-
-	buffer := bytes.NewBuffer([]byte{})
-	binary.Write(buffer, binary.BigEndian, uint8(PUSH_COMMAND_VALUE))
-	binary.Write(buffer, binary.BigEndian, uint32(frameBuffer.Len()))
-	binary.Write(buffer, binary.BigEndian, frameBuffer.Bytes())
-	return buffer.Bytes(), nil
+	// 5 TLV items, each with a 1-byte id + 2-byte length header.
+	frameLen := 3 + len(token) +
+		3 + len(payload) +
+		3 + NotificationIdentifierLength +
+		3 + ExpirationDateLength +
+		3 + PriorityLength
+	total := 5 + frameLen // + 1-byte command + 4-byte frame length
+
+	start := len(dst)
+	dst = append(dst, make([]byte, total)...)
+	buf := dst[start:]
+
+	buf[0] = uint8(PUSH_COMMAND_VALUE)
+	binary.BigEndian.PutUint32(buf[1:5], uint32(frameLen))
+
+	i := 5
+	buf[i] = DeviceTokenItemid
+	binary.BigEndian.PutUint16(buf[i+1:i+3], uint16(len(token)))
+	i += 3
+	i += copy(buf[i:], token)
+
+	buf[i] = PayloadItemid
+	binary.BigEndian.PutUint16(buf[i+1:i+3], uint16(len(payload)))
+	i += 3
+	i += copy(buf[i:], payload)
+
+	buf[i] = NotificationIdentifierItemid
+	binary.BigEndian.PutUint16(buf[i+1:i+3], uint16(NotificationIdentifierLength))
+	i += 3
+	binary.BigEndian.PutUint32(buf[i:i+4], uint32(this.Identifier))
+	i += 4
+
+	buf[i] = ExpirationDateItemid
+	binary.BigEndian.PutUint16(buf[i+1:i+3], uint16(ExpirationDateLength))
+	i += 3
+	binary.BigEndian.PutUint32(buf[i:i+4], this.Expiry)
+	i += 4
+
+	buf[i] = PriorityItemid
+	binary.BigEndian.PutUint16(buf[i+1:i+3], uint16(PriorityLength))
+	i += 3
+	buf[i] = this.Priority
+
+	return dst, nil
 }
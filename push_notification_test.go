@@ -0,0 +1,127 @@
+package apns
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+)
+
+func TestPayloadZeroBadgeRoundTrips(t *testing.T) {
+	pn := NewPushNotification()
+	p := NewPayload()
+	p.SetBadge(0)
+	pn.AddPayload(p)
+
+	js, err := pn.PayloadJSON()
+	if err != nil {
+		t.Fatalf("PayloadJSON: %v", err)
+	}
+
+	var decoded struct {
+		Aps struct {
+			Badge *int `json:"badge"`
+		} `json:"aps"`
+	}
+	if err := json.Unmarshal(js, &decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if decoded.Aps.Badge == nil {
+		t.Fatal("badge was omitted from the payload, want explicit 0")
+	}
+	if *decoded.Aps.Badge != 0 {
+		t.Errorf("badge = %d, want 0", *decoded.Aps.Badge)
+	}
+}
+
+func TestPayloadUnsetBadgeOmitted(t *testing.T) {
+	pn := NewPushNotification()
+	pn.AddPayload(NewPayload())
+
+	js, err := pn.PayloadJSON()
+	if err != nil {
+		t.Fatalf("PayloadJSON: %v", err)
+	}
+	if string(js) != `{"aps":{}}` {
+		t.Errorf("payload = %s, want an empty aps object with no badge key", js)
+	}
+}
+
+func TestPayloadDoesNotMutateCaller(t *testing.T) {
+	p := NewPayload()
+	p.SetBadge(5)
+
+	pn1 := NewPushNotification()
+	pn1.AddPayload(p)
+
+	if *p.Badge != 5 {
+		t.Fatalf("AddPayload mutated the caller's Payload.Badge to %d", *p.Badge)
+	}
+}
+
+func TestToBytesFrame(t *testing.T) {
+	pn := testPushNotification(99)
+	pn.Expiry = 123456
+
+	frame, err := pn.ToBytes()
+	if err != nil {
+		t.Fatalf("ToBytes: %v", err)
+	}
+
+	if got := frame[0]; got != PUSH_COMMAND_VALUE {
+		t.Errorf("command byte = %d, want %d", got, PUSH_COMMAND_VALUE)
+	}
+
+	frameLen := binary.BigEndian.Uint32(frame[1:5])
+	if int(frameLen) != len(frame)-5 {
+		t.Errorf("frame length header = %d, want %d", frameLen, len(frame)-5)
+	}
+
+	id, ok := findIdentifierItem(frame[5:])
+	if !ok {
+		t.Fatal("frame did not contain a notification identifier item")
+	}
+	if id != 99 {
+		t.Errorf("identifier item = %d, want 99", id)
+	}
+}
+
+// findIdentifierItem walks the TLV items in a frame body looking for the
+// notification identifier, mirroring the layout ToBytes/AppendBytes write.
+func findIdentifierItem(body []byte) (int32, bool) {
+	for i := 0; i+3 <= len(body); {
+		itemID := body[i]
+		itemLen := binary.BigEndian.Uint16(body[i+1 : i+3])
+		itemStart := i + 3
+		if itemID == NotificationIdentifierItemid {
+			return int32(binary.BigEndian.Uint32(body[itemStart : itemStart+int(itemLen)])), true
+		}
+		i = itemStart + int(itemLen)
+	}
+	return 0, false
+}
+
+func TestAppendBytesReusesBuffer(t *testing.T) {
+	pn1 := testPushNotification(1)
+	pn2 := testPushNotification(2)
+
+	buf := make([]byte, 0, 512)
+	buf, err := pn1.AppendBytes(buf)
+	if err != nil {
+		t.Fatalf("AppendBytes #1: %v", err)
+	}
+	firstLen := len(buf)
+
+	buf, err = pn2.AppendBytes(buf)
+	if err != nil {
+		t.Fatalf("AppendBytes #2: %v", err)
+	}
+
+	want1, _ := pn1.ToBytes()
+	want2, _ := pn2.ToBytes()
+	if string(buf[:firstLen]) != string(want1) {
+		t.Error("first frame in the reused buffer doesn't match ToBytes")
+	}
+	if string(buf[firstLen:]) != string(want2) {
+		t.Error("second frame in the reused buffer doesn't match ToBytes")
+	}
+}